@@ -7,6 +7,8 @@ package dlock
 import (
 	"context"
 	"errors"
+	"math/rand"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +18,16 @@ import (
 const (
 	// storePrefix used to prefix lock related keys in KV store.
 	storePrefix = "dlock:"
+
+	// writerKeySuffix marks the key an exclusive Lock() holder claims.
+	writerKeySuffix = ":writers"
+
+	// readerKeySuffix prefixes the per-holder keys RLock() claims. each reader
+	// gets its own key so many readers can hold the lock at the same time.
+	readerKeySuffix = ":readers:"
+
+	// listPageSize is the page size used while listing reader keys.
+	listPageSize = 100
 )
 
 const (
@@ -28,42 +40,107 @@ const (
 
 	// lockTryInterval used to wait before trying to obtain the lock again.
 	lockTryInterval = time.Second
+
+	// refreshRetryInterval used to wait before retrying a failed renewal.
+	refreshRetryInterval = 100 * time.Millisecond
+
+	// defaultRetryIntervalMax is the default cap for the acquisition loop's
+	// exponential backoff.
+	defaultRetryIntervalMax = time.Second * 10
+
+	// defaultJitterFraction is how much, as a fraction of the current backoff
+	// interval, the acquisition loop's wait is randomized by, to avoid
+	// synchronized retries from callers contending on the same key.
+	defaultJitterFraction = 0.25
+
+	// defaultUnlockTimeout bounds how long UnlockContext/RUnlockContext wait
+	// on a hung refresh loop or store before giving up.
+	defaultUnlockTimeout = time.Minute
+
+	// unlockRetryInterval used to wait before retrying a failed KVDelete
+	// while unlocking.
+	unlockRetryInterval = 100 * time.Millisecond
 )
 
 var (
 	// ErrCouldntObtainImmediately returned when a lock couldn't be obtained immediately after
 	// calling Lock().
 	ErrCouldntObtainImmediately = errors.New("could not obtain immediately")
+
+	// ErrInvalidRefreshInterval returned when the configured refresh interval
+	// isn't less than half of the configured TTL, which would let the lock
+	// expire between refreshes.
+	ErrInvalidRefreshInterval = errors.New("refresh interval must be less than half of TTL")
+
+	// ErrUnlockTimeout returned by UnlockContext/RUnlockContext when the
+	// unlock timeout fires before the lock could be released, so callers can
+	// log it and move on instead of deadlocking on a hung store.
+	ErrUnlockTimeout = errors.New("timed out waiting to unlock")
 )
 
 // Store is a data store to keep locks' state.
 type Store interface {
 	KVSetWithOptions(key string, newValue interface{}, options model.PluginKVSetOptions) (bool, *model.AppError)
 	KVDelete(key string) *model.AppError
+	KVGet(key string) ([]byte, *model.AppError)
+	KVList(page, perPage int) ([]string, *model.AppError)
 }
 
-// DLock is a distributed lock.
+// DLock is a distributed lock. it behaves like a sync.RWMutex: Lock()/Unlock()
+// give exclusive access, RLock()/RUnlock() give shared access to any number of
+// readers as long as no writer holds the lock. like sync.RWMutex, a single
+// DLock may be shared by many goroutines, including many concurrent
+// RLock()/RUnlock() callers.
 type DLock struct {
 	// store used to store lock's state to do synchronization.
 	store Store
 
-	// key to lock for.
+	// key is the base key this lock was created for.
 	key string
 
+	// writerKey is the key an exclusive Lock() holder claims.
+	writerKey string
+
 	// defaultOptions are overwritten by call to Lock() or RLock().
 	defaultOptions []Option
 
-	// refreshCancel stops refreshing lock's TTL.
+	// refreshCancel stops refreshing the writer key's TTL. only touched by
+	// Lock()/UnlockContext(), which the store's exclusivity on writerKey
+	// already serializes to one in-flight holder at a time.
 	refreshCancel context.CancelFunc
 
-	// refreshWait is a waiter to make sure refreshing is finished.
+	// refreshWait is a waiter to make sure the writer key's refresh loop
+	// finished. see refreshCancel.
 	refreshWait *sync.WaitGroup
+
+	// readersMu protects readers, since unlike the writer key, many RLock()
+	// calls can be in flight for this DLock at once.
+	readersMu sync.Mutex
+
+	// readers holds one lease per currently held RLock() call on this DLock.
+	readers []*readerLease
+}
+
+// readerLease tracks the state a single RLock() call needs to later release
+// its own reader key, independent of any other concurrent readers sharing
+// the same DLock.
+type readerLease struct {
+	key           string
+	refreshCancel context.CancelFunc
+	refreshWait   *sync.WaitGroup
 }
 
 // configuration keeps lock configurations.
 type configuration struct {
-	ctx               context.Context
-	obtainImmediately bool
+	ctx                   context.Context
+	obtainImmediately     bool
+	refreshabilityTimeout time.Duration
+	ttl                   time.Duration
+	refreshInterval       time.Duration
+	retryIntervalStart    time.Duration
+	retryIntervalMax      time.Duration
+	jitterFraction        float64
+	unlockTimeout         time.Duration
 }
 
 // Option modifies configuration.
@@ -85,6 +162,74 @@ func ObtainImmediatelyOption() Option {
 	}
 }
 
+// TTLOption overrides how long the lock's KV entry lives before the store
+// expires it on its own. the store expires keys in whole seconds, so ttl is
+// rounded up to the next whole second. defaults to lockTTL.
+func TTLOption(ttl time.Duration) Option {
+	return func(c *configuration) {
+		c.ttl = ttl
+	}
+}
+
+// RefreshIntervalOption overrides how often the refresh loop renews the
+// lock's TTL. it must be less than half of the TTL, or Lock()/RLock() return
+// ErrInvalidRefreshInterval. defaults to lockRefreshInterval.
+func RefreshIntervalOption(interval time.Duration) Option {
+	return func(c *configuration) {
+		c.refreshInterval = interval
+	}
+}
+
+// RefreshabilityTimeoutOption overrides how long the refresh loop keeps
+// retrying a failed renewal, since the last successful one, before giving up
+// and cancelling the context returned by LockWithContext/RLockWithContext.
+// defaults to ttl - refreshInterval*3/2.
+func RefreshabilityTimeoutOption(timeout time.Duration) Option {
+	return func(c *configuration) {
+		c.refreshabilityTimeout = timeout
+	}
+}
+
+// RetryIntervalOption overrides the starting interval Lock()/RLock() wait
+// between failed acquisition attempts. the wait doubles on every subsequent
+// attempt, up to defaultRetryIntervalMax or the max set by BackoffOption.
+// defaults to lockTryInterval.
+func RetryIntervalOption(interval time.Duration) Option {
+	return func(c *configuration) {
+		c.retryIntervalStart = interval
+	}
+}
+
+// BackoffOption overrides both ends of the acquisition loop's exponential
+// backoff: min is the starting wait between attempts, max is the cap it
+// doubles up to.
+func BackoffOption(min, max time.Duration) Option {
+	return func(c *configuration) {
+		c.retryIntervalStart = min
+		c.retryIntervalMax = max
+	}
+}
+
+// JitterOption overrides how much, as a fraction of the current backoff
+// interval, the acquisition loop's wait is randomized by (e.g. 0.25 for
+// ±25%). this spreads out retries from callers contending on the same key so
+// they don't all hit the store at once. defaults to defaultJitterFraction.
+func JitterOption(fraction float64) Option {
+	return func(c *configuration) {
+		c.jitterFraction = fraction
+	}
+}
+
+// UnlockTimeoutOption overrides how long UnlockContext/RUnlockContext wait on
+// a hung refresh loop or store before giving up with ErrUnlockTimeout. set
+// through New(), since Unlock()/UnlockContext() take no options of their
+// own. defaults to defaultUnlockTimeout.
+func UnlockTimeoutOption(timeout time.Duration) Option {
+	return func(c *configuration) {
+		c.unlockTimeout = timeout
+	}
+}
+
 // New creates a new distributed lock for key on given store with options.
 // think,
 //   `dl := New("my-key", store)`
@@ -92,8 +237,10 @@ func ObtainImmediatelyOption() Option {
 //   `var m sync.Mutex`
 // and use it in the same way.
 func New(key string, store Store, options ...Option) *DLock {
+	baseKey := buildKey(key)
 	d := &DLock{
-		key:            buildKey(key),
+		key:            baseKey,
+		writerKey:      baseKey + writerKeySuffix,
 		defaultOptions: options,
 		store:          store,
 	}
@@ -101,7 +248,7 @@ func New(key string, store Store, options ...Option) *DLock {
 }
 
 // createConfig creates a new config by merging options with default ones.
-func (d *DLock) createConfig(options ...Option) *configuration {
+func (d *DLock) createConfig(options ...Option) (*configuration, error) {
 	c := &configuration{}
 	options = append(d.defaultOptions, options...)
 	for _, o := range options {
@@ -110,71 +257,457 @@ func (d *DLock) createConfig(options ...Option) *configuration {
 	if c.ctx == nil {
 		c.ctx = context.Background()
 	}
-	return c
+	if c.ttl == 0 {
+		c.ttl = lockTTL
+	}
+	if c.refreshInterval == 0 {
+		c.refreshInterval = lockRefreshInterval
+	}
+	if c.retryIntervalStart == 0 {
+		c.retryIntervalStart = lockTryInterval
+	}
+	if c.retryIntervalMax == 0 {
+		c.retryIntervalMax = defaultRetryIntervalMax
+	}
+	if c.jitterFraction == 0 {
+		c.jitterFraction = defaultJitterFraction
+	}
+	if c.unlockTimeout == 0 {
+		c.unlockTimeout = defaultUnlockTimeout
+	}
+	if c.refreshabilityTimeout == 0 {
+		c.refreshabilityTimeout = c.ttl - c.refreshInterval*3/2
+	}
+	if c.refreshInterval >= c.ttl/2 {
+		return nil, ErrInvalidRefreshInterval
+	}
+	return c, nil
 }
 
-// Lock obtains a new lock.
+// Lock obtains an exclusive lock, waiting for any current readers to release
+// the key first.
 // use Lock() exactly like sync.Mutex.Lock(), avoid missuses like deadlocks.
 func (d *DLock) Lock(options ...Option) error {
+	_, err := d.LockWithContext(options...)
+	return err
+}
+
+// LockWithContext is Lock(), but it also returns a context that's cancelled
+// once the refresh loop fails to renew the lock for longer than the
+// configured refreshability timeout (network partition, a paused goroutine
+// past the TTL, etc), or at once when a renewal finds the key gone (KV
+// eviction, expiry). callers holding a long critical section
+// should select on this context and abort their work when it fires. Unlock()
+// remains safe to call after the lock was lost this way.
+func (d *DLock) LockWithContext(options ...Option) (context.Context, error) {
+	conf, err := d.createConfig(options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.retryUntil(conf, func() (bool, *model.AppError) {
+		return d.claimKey(d.writerKey, conf.ttl)
+	}); err != nil {
+		return nil, err
+	}
+	for {
+		if err := d.retryUntil(conf, d.noReadersRemain); err != nil {
+			// readers never cleared out (or ctx was cancelled), give back
+			// the writer key we already claimed so other writers/readers
+			// aren't blocked on us forever.
+			d.store.KVDelete(d.writerKey)
+			return nil, err
+		}
+		// a reader that observed no writer key just before we claimed it
+		// above may still be mid-flight claiming its own reader key. confirm
+		// none slipped in before calling acquisition final; if one did,
+		// go back to waiting it out instead of returning with both a
+		// writer and a reader holding the lock at once.
+		clear, aerr := d.noReadersRemain()
+		if aerr != nil {
+			d.store.KVDelete(d.writerKey)
+			return nil, normalizeAppErr(aerr)
+		}
+		if clear {
+			break
+		}
+	}
+	ctx, cancel, wait := d.startRefreshLoop(d.writerKey, conf.ttl, conf.refreshInterval, conf.refreshabilityTimeout)
+	d.refreshCancel = cancel
+	d.refreshWait = wait
+	return ctx, nil
+}
+
+// RLock obtains a shared read lock. it refuses to proceed while an exclusive
+// writer holds the lock, and otherwise claims its own reader-scoped key so
+// multiple readers can hold the lock concurrently.
+func (d *DLock) RLock(options ...Option) error {
+	_, err := d.RLockWithContext(options...)
+	return err
+}
+
+// RLockWithContext is RLock(), but it also returns a context that's cancelled
+// once the refresh loop fails to renew the lock for longer than the
+// configured refreshability timeout. see LockWithContext for details.
+func (d *DLock) RLockWithContext(options ...Option) (context.Context, error) {
+	conf, err := d.createConfig(options...)
+	if err != nil {
+		return nil, err
+	}
+	interval := conf.retryIntervalStart
+	for {
+		if err := d.retryUntil(conf, d.noWriterPresent); err != nil {
+			return nil, err
+		}
+		readerKey := d.key + readerKeySuffix + model.NewId()
+		claimed, aerr := d.claimKey(readerKey, conf.ttl)
+		if aerr != nil {
+			return nil, normalizeAppErr(aerr)
+		}
+		if !claimed {
+			// readerKey is unique per call, so this is an astronomically
+			// unlikely ID collision rather than real contention; just
+			// retry with a fresh one.
+			continue
+		}
+		// a writer may have claimed the writer key in the window between our
+		// check above and our claim just now. re-verify before treating
+		// acquisition as final, since otherwise a writer and a reader could
+		// both believe they hold the lock at once.
+		noWriter, aerr := d.noWriterPresent()
+		if aerr != nil {
+			d.store.KVDelete(readerKey)
+			return nil, normalizeAppErr(aerr)
+		}
+		if noWriter {
+			ctx, cancel, wait := d.startRefreshLoop(readerKey, conf.ttl, conf.refreshInterval, conf.refreshabilityTimeout)
+			d.pushReaderLease(&readerLease{key: readerKey, refreshCancel: cancel, refreshWait: wait})
+			return ctx, nil
+		}
+		// a writer slipped in while we were claiming our reader key; give it
+		// back and retry from the top.
+		d.store.KVDelete(readerKey)
+		if conf.obtainImmediately {
+			return nil, ErrCouldntObtainImmediately
+		}
+		if err := d.waitBackoff(conf, &interval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pushReaderLease registers lease as one of this DLock's currently held
+// read locks.
+func (d *DLock) pushReaderLease(lease *readerLease) {
+	d.readersMu.Lock()
+	d.readers = append(d.readers, lease)
+	d.readersMu.Unlock()
+}
+
+// popReaderLease removes and returns one of this DLock's currently held read
+// locks, for RUnlock() to release. readers are fungible, so it doesn't matter
+// which one is returned, as long as every RLock() is paired with exactly one
+// RUnlock(). it panics if no read lock is held, same as sync.RWMutex.RUnlock()
+// panics on an unmatched call.
+func (d *DLock) popReaderLease() *readerLease {
+	d.readersMu.Lock()
+	defer d.readersMu.Unlock()
+	n := len(d.readers)
+	if n == 0 {
+		panic("dlock: RUnlock of unlocked DLock")
+	}
+	lease := d.readers[n-1]
+	d.readers = d.readers[:n-1]
+	return lease
+}
+
+// claimKey atomically claims key, reporting whether it was obtained. a CAS
+// mismatch (key already claimed by someone else) reports ok == false with no
+// error, same as noReadersRemain/noWriterPresent, so callers can tell it
+// apart from a transient store error and retry instead of failing outright.
+func (d *DLock) claimKey(key string, ttl time.Duration) (bool, *model.AppError) {
 	kopts := model.PluginKVSetOptions{
 		EncodeJSON:      true,
 		Atomic:          true,
 		OldValue:        nil,
-		ExpireInSeconds: int64(lockTTL.Seconds()),
+		ExpireInSeconds: expireInSeconds(ttl),
 	}
-	conf := d.createConfig(options...)
+	return d.store.KVSetWithOptions(key, true, kopts)
+}
+
+// expireInSeconds converts ttl to the whole seconds the store's expiry is
+// set in, rounding up so that a sub-second ttl still expires instead of
+// becoming 0, which the store takes as no expiry at all.
+func expireInSeconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// noWriterPresent reports whether no exclusive writer currently holds the lock.
+func (d *DLock) noWriterPresent() (bool, *model.AppError) {
+	val, aerr := d.store.KVGet(d.writerKey)
+	if aerr != nil {
+		return false, aerr
+	}
+	return val == nil, nil
+}
+
+// noReadersRemain reports whether no reader currently holds the lock.
+func (d *DLock) noReadersRemain() (bool, *model.AppError) {
+	keys, aerr := d.listKeysWithPrefix(d.key + readerKeySuffix)
+	if aerr != nil {
+		return false, aerr
+	}
+	return len(keys) == 0, nil
+}
+
+// listKeysWithPrefix lists all KV store keys starting with prefix.
+func (d *DLock) listKeysWithPrefix(prefix string) ([]string, *model.AppError) {
+	var matches []string
+	for page := 0; ; page++ {
+		keys, aerr := d.store.KVList(page, listPageSize)
+		if aerr != nil {
+			return nil, aerr
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				matches = append(matches, key)
+			}
+		}
+		if len(keys) < listPageSize {
+			return matches, nil
+		}
+	}
+}
+
+// retryUntil calls try until it reports success, waiting a jittered,
+// exponentially growing interval between attempts (starting at
+// conf.retryIntervalStart, doubling up to conf.retryIntervalMax) so that
+// callers contending on the same key don't retry in lockstep. it respects
+// conf's ContextOption and ObtainImmediatelyOption, observing cancellation
+// promptly instead of waiting out the current interval.
+func (d *DLock) retryUntil(conf *configuration, try func() (bool, *model.AppError)) error {
+	interval := conf.retryIntervalStart
 	for {
-		_, aerr := d.store.KVSetWithOptions(d.key, true, kopts)
-		isLockObtained := aerr == nil
-		if isLockObtained {
-			d.startRefreshLoop()
+		ok, aerr := try()
+		if aerr != nil {
+			return normalizeAppErr(aerr)
+		}
+		if ok {
 			return nil
 		}
 		if conf.obtainImmediately {
 			return ErrCouldntObtainImmediately
 		}
-		afterC := time.After(lockTryInterval)
-		select {
-		case <-conf.ctx.Done():
-			return conf.ctx.Err()
-		case <-afterC:
+		if err := d.waitBackoff(conf, &interval); err != nil {
+			return err
+		}
+	}
+}
+
+// waitBackoff waits a jittered interval, then grows interval for the next
+// call, capped at conf.retryIntervalMax. it returns conf.ctx's error if ctx
+// is done before the wait elapses.
+func (d *DLock) waitBackoff(conf *configuration, interval *time.Duration) error {
+	t := time.NewTimer(jitter(*interval, conf.jitterFraction))
+	select {
+	case <-conf.ctx.Done():
+		if !t.Stop() {
+			<-t.C
 		}
+		return conf.ctx.Err()
+	case <-t.C:
+	}
+	*interval *= 2
+	if *interval > conf.retryIntervalMax {
+		*interval = conf.retryIntervalMax
+	}
+	return nil
+}
+
+// jitter randomizes d by up to ±fraction, e.g. jitter(time.Second, 0.25)
+// returns somewhere between 750ms and 1.25s.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	delta := (rand.Float64()*2 - 1) * fraction * float64(d)
+	jittered := d + time.Duration(delta)
+	if jittered < 0 {
+		return 0
 	}
+	return jittered
 }
 
 // startRefreshLoop refreshes an obtained lock to not get caught by lock's TTL.
 // TTL tends to hit and release the lock automatically when plugin terminates.
-func (d *DLock) startRefreshLoop() {
+//
+// it returns a context that's cancelled when the caller stops the loop via
+// the returned cancel func (normal Unlock/RUnlock), when renewal has been
+// failing for longer than refreshabilityTimeout, or when a renewal finds the
+// key gone; in the last two cases the lock is considered lost. the returned wait group is done once the loop has
+// actually exited, so callers can block on it after cancelling.
+func (d *DLock) startRefreshLoop(key string, ttl, refreshInterval, refreshabilityTimeout time.Duration) (context.Context, context.CancelFunc, *sync.WaitGroup) {
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		t := time.NewTicker(lockRefreshInterval)
-		kopts := model.PluginKVSetOptions{
-			EncodeJSON:      true,
-			ExpireInSeconds: int64(lockTTL.Seconds()),
-		}
+		defer cancel()
+		t := time.NewTicker(refreshInterval)
+		defer t.Stop()
+		lastSuccess := time.Now()
 		for {
 			select {
 			case <-t.C:
-				d.store.KVSetWithOptions(d.key, true, kopts)
+				if !d.renewUntilSuccessOrTimeout(ctx, key, ttl, refreshabilityTimeout, &lastSuccess) {
+					return
+				}
 			case <-ctx.Done():
 				return
 			}
 		}
 	}()
-	d.refreshCancel = cancel
-	d.refreshWait = &wg
+	return ctx, cancel, &wg
 }
 
-// Unlock unlocks Lock().
+// renewUntilSuccessOrTimeout retries refreshKey with a short backoff until it
+// succeeds, ctx is done, the key is confirmed vanished, or
+// refreshabilityTimeout has elapsed since lastSuccess, whichever comes first.
+// it reports whether the lock is still held, updating lastSuccess on every
+// successful renewal.
+func (d *DLock) renewUntilSuccessOrTimeout(ctx context.Context, key string, ttl, refreshabilityTimeout time.Duration, lastSuccess *time.Time) bool {
+	for {
+		ok, vanished := d.refreshKey(key, ttl)
+		if ok {
+			*lastSuccess = time.Now()
+			return true
+		}
+		if vanished {
+			// the CAS mismatch is unambiguous: the key is gone, not just
+			// unreachable, so there's nothing left to keep renewing.
+			return false
+		}
+		if time.Since(*lastSuccess) >= refreshabilityTimeout {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(refreshRetryInterval):
+		}
+	}
+}
+
+// refreshKey renews key's TTL, reporting whether it still held the lock's
+// expected value. vanished is true only when the CAS itself succeeded but
+// reported a mismatch, meaning the key was confirmed evicted or deleted from
+// under us; any other failure (aerr != nil) is a transient store error that
+// renewUntilSuccessOrTimeout should keep retrying instead of treating as loss.
+func (d *DLock) refreshKey(key string, ttl time.Duration) (ok, vanished bool) {
+	kopts := model.PluginKVSetOptions{
+		EncodeJSON:      true,
+		Atomic:          true,
+		OldValue:        true,
+		ExpireInSeconds: expireInSeconds(ttl),
+	}
+	result, aerr := d.store.KVSetWithOptions(key, true, kopts)
+	if aerr != nil {
+		return false, false
+	}
+	return result, !result
+}
+
+// Unlock unlocks Lock(). it's UnlockContext(context.Background()).
 // use Unlock() exactly like sync.Mutex.Unlock().
 func (d *DLock) Unlock() error {
-	d.refreshCancel()
-	d.refreshWait.Wait()
-	aerr := d.store.KVDelete(d.key)
-	return normalizeAppErr(aerr)
+	return d.UnlockContext(context.Background())
+}
+
+// UnlockContext is Unlock(), bounded by ctx and by the configured
+// UnlockTimeoutOption (default one minute): it stops the refresh goroutine,
+// waits for it to exit, and deletes the writer key, retrying on transient
+// store failures. if the wait or the deletes don't finish before ctx is done
+// or the timeout elapses, it returns ErrUnlockTimeout instead of blocking
+// forever, so shutdown code can log it and move on. the refresh goroutine is
+// cancelled even if this returns early, and exits as soon as any store call
+// it's in returns.
+func (d *DLock) UnlockContext(ctx context.Context) error {
+	return d.unlockContext(ctx, d.writerKey, d.refreshCancel, d.refreshWait)
+}
+
+// RUnlock unlocks RLock(), releasing only the caller's own reader key. it's
+// RUnlockContext(context.Background()). safe to call concurrently with other
+// RLock()/RUnlock() calls on the same DLock; each pairs with exactly one
+// reader lease, same as sync.RWMutex.RUnlock().
+func (d *DLock) RUnlock() error {
+	return d.RUnlockContext(context.Background())
+}
+
+// RUnlockContext is RUnlock(), bounded the same way as UnlockContext.
+func (d *DLock) RUnlockContext(ctx context.Context) error {
+	lease := d.popReaderLease()
+	return d.unlockContext(ctx, lease.key, lease.refreshCancel, lease.refreshWait)
+}
+
+// unlockContext stops the refresh loop behind cancel/wait and deletes key,
+// bailing out with ErrUnlockTimeout once ctx is done or the configured
+// unlock timeout elapses, whichever comes first.
+func (d *DLock) unlockContext(ctx context.Context, key string, cancel context.CancelFunc, wait *sync.WaitGroup) error {
+	cancel()
+
+	deadline := time.Now().Add(d.unlockTimeout())
+	waitDone := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(waitDone)
+	}()
+
+	select {
+	case <-waitDone:
+	case <-ctx.Done():
+		return ErrUnlockTimeout
+	case <-time.After(time.Until(deadline)):
+		return ErrUnlockTimeout
+	}
+
+	for {
+		deleted := make(chan *model.AppError, 1)
+		go func() {
+			deleted <- d.store.KVDelete(key)
+		}()
+		var aerr *model.AppError
+		select {
+		case aerr = <-deleted:
+		case <-ctx.Done():
+			return ErrUnlockTimeout
+		case <-time.After(time.Until(deadline)):
+			return ErrUnlockTimeout
+		}
+		if aerr == nil {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return ErrUnlockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ErrUnlockTimeout
+		case <-time.After(unlockRetryInterval):
+		}
+	}
+}
+
+// unlockTimeout returns the configured UnlockTimeoutOption, or
+// defaultUnlockTimeout if none was set. Unlock()/UnlockContext() take no
+// per-call options, so this only looks at the lock's default options.
+func (d *DLock) unlockTimeout() time.Duration {
+	c := &configuration{}
+	for _, o := range d.defaultOptions {
+		o(c)
+	}
+	if c.unlockTimeout == 0 {
+		return defaultUnlockTimeout
+	}
+	return c.unlockTimeout
 }
 
 // buildKey builds a lock key for KV store.
@@ -1,14 +1,42 @@
 package dlock
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/mattermost/mattermost-server/model"
 	"github.com/stretchr/testify/require"
 )
 
+// faultyStore wraps a Store and, once armed via setFail, makes every
+// KVSetWithOptions call fail with a store error instead of reaching the
+// underlying store, to simulate a network partition or KV outage during
+// refresh.
+type faultyStore struct {
+	Store
+	mu   sync.Mutex
+	fail bool
+}
+
+func (s *faultyStore) setFail(fail bool) {
+	s.mu.Lock()
+	s.fail = fail
+	s.mu.Unlock()
+}
+
+func (s *faultyStore) KVSetWithOptions(key string, newValue interface{}, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	s.mu.Lock()
+	fail := s.fail
+	s.mu.Unlock()
+	if fail {
+		return false, model.NewAppError("faultyStore.KVSetWithOptions", "dlock.test.injected_failure", nil, "", 500)
+	}
+	return s.Store.KVSetWithOptions(key, newValue, options)
+}
+
 // TODO(ilgooz): test all branches including related ones to Store errors and ExpireInSeconds.
 // TODO(ilgooz): can move tests from sync/mutex_test.go.
 
@@ -44,3 +72,235 @@ func TestLockDifferentKeys(t *testing.T) {
 	dla.Unlock()
 	dlb.Unlock()
 }
+
+func TestInvalidRefreshInterval(t *testing.T) {
+	dl := New("c", dlocktest.NewStore(), TTLOption(100*time.Millisecond), RefreshIntervalOption(60*time.Millisecond))
+	err := dl.Lock()
+	require.Equal(t, ErrInvalidRefreshInterval, err)
+}
+
+func TestTTLExpiresWithoutRefresh(t *testing.T) {
+	store := dlocktest.NewStore()
+	dl := New("d", store, TTLOption(50*time.Millisecond), RefreshIntervalOption(20*time.Millisecond))
+	require.NoError(t, dl.Lock())
+
+	// simulate a refresh loop that stopped renewing without an Unlock(), e.g.
+	// because the holder crashed.
+	dl.refreshCancel()
+	dl.refreshWait.Wait()
+	// the store expires keys in whole seconds, so the 50ms TTL was rounded
+	// up to 1s.
+	time.Sleep(1500 * time.Millisecond)
+
+	other := New("d", store)
+	require.NoError(t, other.Lock(ObtainImmediatelyOption()))
+}
+
+func TestCustomRefreshIntervalKeepsLockAlive(t *testing.T) {
+	store := dlocktest.NewStore()
+	ttl := 100 * time.Millisecond
+	dl := New("e", store, TTLOption(ttl), RefreshIntervalOption(20*time.Millisecond))
+	require.NoError(t, dl.Lock())
+	defer dl.Unlock()
+
+	// the store expires keys in whole seconds, so ttl is rounded up to 1s;
+	// sleep well past that, so only the refresh loop keeps the lock held.
+	time.Sleep(3 * time.Second)
+
+	other := New("e", store)
+	err := other.Lock(ObtainImmediatelyOption())
+	require.Equal(t, ErrCouldntObtainImmediately, err)
+}
+
+// TestLockWithContextCancelsOnSustainedRenewalFailure checks that the
+// context returned by LockWithContext is cancelled once renewal has been
+// failing for longer than refreshabilityTimeout, so callers selecting on it
+// find out their critical section is no longer protected.
+func TestLockWithContextCancelsOnSustainedRenewalFailure(t *testing.T) {
+	store := &faultyStore{Store: dlocktest.NewStore()}
+	ttl := 200 * time.Millisecond
+	refreshInterval := 20 * time.Millisecond
+	refreshabilityTimeout := 60 * time.Millisecond
+
+	dl := New("lossctx", store,
+		TTLOption(ttl),
+		RefreshIntervalOption(refreshInterval),
+		RefreshabilityTimeoutOption(refreshabilityTimeout))
+	ctx, err := dl.LockWithContext()
+	require.NoError(t, err)
+
+	// start failing every renewal, as if the store became unreachable.
+	store.setFail(true)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(refreshabilityTimeout + ttl):
+		t.Fatal("lock-loss context wasn't cancelled after sustained renewal failure")
+	}
+
+	// Unlock must remain safe to call after the lock was lost this way.
+	store.setFail(false)
+	require.NoError(t, dl.Unlock())
+}
+
+func TestUnlockContextTimesOutOnCancelledContext(t *testing.T) {
+	dl := New("f", dlocktest.NewStore())
+	require.NoError(t, dl.Lock())
+
+	// simulate a refresh goroutine that's stuck, e.g. on a hung
+	// KVSetWithOptions call, and never exits on its own.
+	dl.refreshWait.Add(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, ErrUnlockTimeout, dl.UnlockContext(ctx))
+}
+
+func TestUnlockContextTimesOutOnDeadline(t *testing.T) {
+	dl := New("g", dlocktest.NewStore(), UnlockTimeoutOption(20*time.Millisecond))
+	require.NoError(t, dl.Lock())
+
+	// simulate a refresh goroutine that's stuck and never exits on its own.
+	dl.refreshWait.Add(1)
+
+	require.Equal(t, ErrUnlockTimeout, dl.UnlockContext(context.Background()))
+}
+
+// TestRLockConcurrentReaders checks that many RLock() calls on the same
+// DLock, from different goroutines, can all be held at once.
+func TestRLockConcurrentReaders(t *testing.T) {
+	dl := New("h", dlocktest.NewStore())
+	const readers = 5
+
+	var wg sync.WaitGroup
+	wg.Add(readers)
+	start := make(chan struct{})
+	for i := 0; i < readers; i++ {
+		go func() {
+			defer wg.Done()
+			require.NoError(t, dl.RLock())
+			<-start
+			require.NoError(t, dl.RUnlock())
+		}()
+	}
+	// give every goroutine a chance to acquire before releasing any of them,
+	// so the test actually exercises overlapping readers rather than a
+	// sequence of acquire/release pairs that happen not to race.
+	time.Sleep(50 * time.Millisecond)
+	close(start)
+	wg.Wait()
+}
+
+// TestRLockBlocksWriter checks that a writer can't acquire the lock while a
+// reader holds it, and proceeds as soon as the reader releases.
+func TestRLockBlocksWriter(t *testing.T) {
+	store := dlocktest.NewStore()
+	dl := New("i", store)
+	require.NoError(t, dl.RLock())
+
+	other := New("i", store)
+	err := other.Lock(ObtainImmediatelyOption())
+	require.Equal(t, ErrCouldntObtainImmediately, err)
+
+	require.NoError(t, dl.RUnlock())
+	require.NoError(t, other.Lock(ObtainImmediatelyOption()))
+	require.NoError(t, other.Unlock())
+}
+
+// TestLockBlocksReader checks that a reader can't acquire the lock while a
+// writer holds it, and proceeds as soon as the writer releases.
+func TestLockBlocksReader(t *testing.T) {
+	store := dlocktest.NewStore()
+	dl := New("j", store)
+	require.NoError(t, dl.Lock())
+
+	other := New("j", store)
+	err := other.RLock(ObtainImmediatelyOption())
+	require.Equal(t, ErrCouldntObtainImmediately, err)
+
+	require.NoError(t, dl.Unlock())
+	require.NoError(t, other.RLock(ObtainImmediatelyOption()))
+	require.NoError(t, other.RUnlock())
+}
+
+// TestRUnlockReleasesOnlyItsOwnKey checks that RUnlock() releases one reader
+// at a time: with two readers held, a single RUnlock() must leave the other
+// reader in place and a writer still blocked.
+func TestRUnlockReleasesOnlyItsOwnKey(t *testing.T) {
+	store := dlocktest.NewStore()
+	dl := New("k", store)
+	require.NoError(t, dl.RLock())
+	require.NoError(t, dl.RLock())
+
+	require.NoError(t, dl.RUnlock())
+
+	other := New("k", store)
+	err := other.Lock(ObtainImmediatelyOption())
+	require.Equal(t, ErrCouldntObtainImmediately, err, "second reader should still hold the lock")
+
+	require.NoError(t, dl.RUnlock())
+	require.NoError(t, other.Lock(ObtainImmediatelyOption()))
+	require.NoError(t, other.Unlock())
+}
+
+// TestRUnlockOfUnlockedDLockPanics checks that RUnlock() without a matching
+// RLock() panics, same as sync.RWMutex.RUnlock().
+func TestRUnlockOfUnlockedDLockPanics(t *testing.T) {
+	dl := New("l", dlocktest.NewStore())
+	require.Panics(t, func() { dl.RUnlock() })
+}
+
+// TestLockStressSameKey contends N goroutines for the same key, each doing
+// several Lock/Unlock rounds with a small starting backoff, and checks that
+// exclusivity held throughout: the jittered backoff in retryUntil should
+// spread out retries instead of synchronizing them, but must never let two
+// goroutines believe they hold the lock at once.
+func TestLockStressSameKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+
+	const goroutines = 20
+	const itersPerGoroutine = 25
+
+	store := dlocktest.NewStore()
+	var held bool
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			dl := New("stress", store, RetryIntervalOption(5*time.Millisecond))
+			for j := 0; j < itersPerGoroutine; j++ {
+				require.NoError(t, dl.Lock())
+
+				mu.Lock()
+				require.False(t, held, "lock held by two goroutines at once")
+				held = true
+				mu.Unlock()
+
+				mu.Lock()
+				held = false
+				mu.Unlock()
+
+				require.NoError(t, dl.Unlock())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkLockUnlock measures the cost of an uncontended Lock/Unlock round
+// trip against the store.
+func BenchmarkLockUnlock(b *testing.B) {
+	dl := New("bench", dlocktest.NewStore())
+	for i := 0; i < b.N; i++ {
+		if err := dl.Lock(); err != nil {
+			b.Fatal(err)
+		}
+		if err := dl.Unlock(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package dlock
+
+import (
+	"context"
+	"sync"
+)
+
+// ReleaseFunc releases a lock obtained through a Manager. it's safe to call
+// more than once; only the first call has an effect.
+type ReleaseFunc func()
+
+// Manager tracks one DLock per key on top of a shared Store, so callers
+// don't have to construct, pair up, and garbage collect DLocks by hand.
+// Acquire a lock with Lock or RLock and call the returned ReleaseFunc to
+// give it back.
+type Manager struct {
+	// store used to build every DLock the manager creates.
+	store Store
+
+	// defaultOptions are passed to every DLock the manager creates.
+	defaultOptions []Option
+
+	// mu protects locks.
+	mu sync.Mutex
+
+	// locks holds the in-flight lock for every key currently held by at
+	// least one local caller.
+	locks map[string]*managedLock
+}
+
+// managedLock is a reference-counted DLock shared by local callers of the
+// same key. localMu mirrors dl's own semantics locally: RLock holds it for
+// reading, so local readers run concurrently, and Lock holds it for writing,
+// so a local writer still excludes every other local caller.
+type managedLock struct {
+	dl       *DLock
+	localMu  sync.RWMutex
+	refCount int
+}
+
+// NewManager creates a new Manager backed by store. options are used as
+// default options for every DLock the manager creates.
+func NewManager(store Store, options ...Option) *Manager {
+	return &Manager{
+		store:          store,
+		defaultOptions: options,
+		locks:          make(map[string]*managedLock),
+	}
+}
+
+// Lock obtains an exclusive lock for key, blocking until it's acquired, ctx
+// is cancelled, or acquisition fails. call the returned ReleaseFunc to
+// release it. ctx is only observed once no other local caller of the same
+// key holds it; waiting behind a local holder isn't cancelled by ctx.
+func (m *Manager) Lock(ctx context.Context, key string, options ...Option) (ReleaseFunc, error) {
+	return m.acquire(ctx, key, options, false)
+}
+
+// RLock obtains a shared read lock for key. multiple local callers can hold
+// RLock for the same key at once, same as DLock.RLock. see Lock for usage.
+func (m *Manager) RLock(ctx context.Context, key string, options ...Option) (ReleaseFunc, error) {
+	return m.acquire(ctx, key, options, true)
+}
+
+// acquire gets or creates the managedLock for key, then locks or read-locks
+// its DLock depending on shared.
+func (m *Manager) acquire(ctx context.Context, key string, options []Option, shared bool) (ReleaseFunc, error) {
+	ml := m.open(key)
+	if shared {
+		ml.localMu.RLock()
+	} else {
+		ml.localMu.Lock()
+	}
+
+	allOptions := append([]Option{ContextOption(ctx)}, options...)
+	var err error
+	if shared {
+		err = ml.dl.RLock(allOptions...)
+	} else {
+		err = ml.dl.Lock(allOptions...)
+	}
+	if err != nil {
+		if shared {
+			ml.localMu.RUnlock()
+		} else {
+			ml.localMu.Unlock()
+		}
+		m.close(key, ml)
+		return nil, err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			if shared {
+				ml.dl.RUnlock()
+				ml.localMu.RUnlock()
+			} else {
+				ml.dl.Unlock()
+				ml.localMu.Unlock()
+			}
+			m.close(key, ml)
+		})
+	}
+	return release, nil
+}
+
+// open returns key's managedLock, creating it if necessary, and registers
+// the caller as one of its holders.
+func (m *Manager) open(key string) *managedLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ml, ok := m.locks[key]
+	if !ok {
+		ml = &managedLock{dl: New(key, m.store, m.defaultOptions...)}
+		m.locks[key] = ml
+	}
+	ml.refCount++
+	return ml
+}
+
+// close unregisters the caller as one of key's holders, removing it from
+// locks once the last holder has released.
+func (m *Manager) close(key string, ml *managedLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ml.refCount--
+	if ml.refCount <= 0 {
+		delete(m.locks, key)
+	}
+}
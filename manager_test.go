@@ -0,0 +1,74 @@
+package dlock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerLock(t *testing.T) {
+	m := NewManager(dlocktest.NewStore())
+	release, err := m.Lock(context.Background(), "a")
+	require.NoError(t, err)
+	release()
+}
+
+func TestManagerLockDifferentKeys(t *testing.T) {
+	m := NewManager(dlocktest.NewStore())
+	releaseA, err := m.Lock(context.Background(), "a")
+	require.NoError(t, err)
+	releaseB, err := m.Lock(context.Background(), "b")
+	require.NoError(t, err)
+	releaseA()
+	releaseB()
+}
+
+func TestManagerReleaseIsIdempotent(t *testing.T) {
+	m := NewManager(dlocktest.NewStore())
+	release, err := m.Lock(context.Background(), "a")
+	require.NoError(t, err)
+	release()
+	release()
+}
+
+// TestManagerRLockConcurrentReaders checks that RLock lets several local
+// callers for the same key run concurrently, instead of serializing them
+// like Lock does.
+func TestManagerRLockConcurrentReaders(t *testing.T) {
+	m := NewManager(dlocktest.NewStore())
+	const readers = 5
+
+	var wg sync.WaitGroup
+	wg.Add(readers)
+	start := make(chan struct{})
+	for i := 0; i < readers; i++ {
+		go func() {
+			defer wg.Done()
+			release, err := m.RLock(context.Background(), "a")
+			require.NoError(t, err)
+			<-start
+			release()
+		}()
+	}
+	// give every goroutine a chance to acquire before releasing any of them,
+	// so the test actually exercises overlapping readers.
+	time.Sleep(50 * time.Millisecond)
+	close(start)
+	wg.Wait()
+}
+
+func TestManagerRemovesEntryAfterRelease(t *testing.T) {
+	m := NewManager(dlocktest.NewStore())
+	release, err := m.Lock(context.Background(), "a")
+	require.NoError(t, err)
+	release()
+	require.Len(t, m.locks, 0)
+
+	release, err = m.Lock(context.Background(), "a")
+	require.NoError(t, err)
+	release()
+}